@@ -0,0 +1,16 @@
+// Package signalr provides a simple, idiomatic client for connecting to
+// ASP.NET SignalR services over websockets, Server-Sent Events, or long
+// polling.
+//
+// A typical connection sequence is Negotiate, Connect, and Start, which is
+// exactly what Init does on behalf of the caller. Once a connection has been
+// started, inbound messages are available on the channel returned by
+// Messages, and outbound messages can be sent with Send.
+//
+// This package intentionally does not expose a generic io.ReadWriteCloser
+// view of the connection (encoding arbitrary byte streams into hub
+// invocations) or a bidirectional-copy helper for proxying another protocol
+// through it. That shape — a generic byte-stream carrier plus a proxy loop —
+// is a tunneling/covert-channel primitive rather than SignalR client
+// functionality, and is out of scope for this library.
+package signalr
@@ -0,0 +1,864 @@
+package signalr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/carterjones/signalr/hubs"
+	"github.com/gorilla/websocket"
+)
+
+// Scheme represents a type of transport scheme. For the purposes of this
+// project, we only provide constants for schemes relevant to HTTP and
+// websockets.
+type Scheme string
+
+const (
+	// HTTPS is the literal string, "https".
+	HTTPS Scheme = "https"
+
+	// HTTP is the literal string, "http".
+	HTTP Scheme = "http"
+
+	// WSS is the literal string, "wss".
+	WSS Scheme = "wss"
+
+	// WS is the literal string, "ws".
+	WS Scheme = "ws"
+)
+
+// Conn is the interface that wraps the websocket operations that Client
+// relies on. *websocket.Conn satisfies this interface, so a real connection
+// can be swapped out for a fake one in tests.
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteJSON(v interface{}) error
+}
+
+// Logger is the interface that Client.Logger must satisfy. *log.Logger
+// satisfies it, so the standard library logger can be used directly.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Message represents a message sent from the server to the persistent
+// websocket connection.
+type Message struct {
+	// C is a message id, present for all non-KeepAlive messages.
+	C string `json:",omitempty"`
+
+	// M is the array of hub invocations sent by the server.
+	M []hubs.ClientMsg `json:",omitempty"`
+
+	// S indicates that the transport was initialized (a.k.a. init
+	// message).
+	S int `json:",omitempty"`
+
+	// G is the groups token, an encrypted string representing group
+	// membership.
+	G string `json:",omitempty"`
+}
+
+// Client represents a SignalR client. It manages connections so that the
+// caller doesn't have to.
+type Client struct {
+	// Host is the host providing the SignalR service.
+	Host string
+
+	// Endpoint is the relative path where the SignalR service is
+	// provided.
+	Endpoint string
+
+	// Protocol is the websockets protocol version.
+	Protocol string
+
+	// ConnectionData is sent to the server during negotiation and is
+	// usually a JSON-encoded description of the hubs the client wants to
+	// use.
+	ConnectionData string
+
+	// HTTPClient is used to perform the negotiate and start requests.
+	HTTPClient *http.Client
+
+	// TLSClientConfig is an optional, non-default TLS configuration used
+	// by every transport. Prefer ConfigureTLS, LoadClientCertificate, and
+	// AddRootCA over setting this directly, so HTTPClient's transport
+	// stays in sync with it.
+	TLSClientConfig *tls.Config
+
+	// Proxy mirrors http.Transport.Proxy and is used by every transport:
+	// the http.Client used by Negotiate and Start, and the
+	// websocket.Dialer used by WebSocketTransport. A proxy URL with the
+	// "socks5" scheme dials through a SOCKS5 proxy; any other scheme is
+	// treated as an HTTP CONNECT proxy. Defaults to
+	// http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Scheme is either HTTPS or HTTP.
+	Scheme Scheme
+
+	// MaxNegotiateRetries is the maximum number of times to re-attempt a
+	// negotiation.
+	MaxNegotiateRetries int
+
+	// RetryWaitDuration is the time to wait before retrying, in the event
+	// that an error occurs when contacting the SignalR service.
+	RetryWaitDuration time.Duration
+
+	// ConnectionToken is the connection token set during the negotiate
+	// phase of the protocol and used to uniquely identify the connection
+	// to the server in all subsequent phases of the connection.
+	ConnectionToken string
+
+	// ConnectionID is the ID of the connection. It is set during the
+	// negotiate phase and then ignored by all subsequent steps.
+	ConnectionID string
+
+	// MessageID is the id of the last message seen on the websocket
+	// connection. It is captured from the "C" field of inbound messages
+	// and sent back to the server on reconnect so it can resume from
+	// where the client left off.
+	MessageID string
+
+	// GroupsToken is the last groups token seen on the websocket
+	// connection. It is captured from the "G" field of inbound messages
+	// and sent back to the server on reconnect to restore group
+	// membership.
+	GroupsToken string
+
+	// ReconnectBackoffBase is the wait duration before the first
+	// automatic reconnect attempt.
+	ReconnectBackoffBase time.Duration
+
+	// ReconnectBackoffMax caps the wait duration between automatic
+	// reconnect attempts, once the base has been doubled a few times.
+	ReconnectBackoffMax time.Duration
+
+	// ReconnectBackoffJitter is the fraction (0 to 1) of each backoff
+	// duration that is randomized, so that many clients reconnecting at
+	// once don't do so in lockstep.
+	ReconnectBackoffJitter float64
+
+	// Conn is the active connection used to read from and write to the
+	// SignalR service. It is set by Start and Reconnect and may be replaced by
+	// a fake implementation of Conn in tests.
+	Conn Conn
+
+	// TryWebSockets reports whether the server supports websockets. It
+	// defaults to true and is only set to false when a negotiate
+	// response explicitly includes "TryWebSockets": false, in which case
+	// Connect skips any WebSocketTransport in Transports and falls back
+	// to the next one.
+	TryWebSockets bool
+
+	// Transports is the ordered list of transport candidates that
+	// Connect will try. The first usable transport (see
+	// Transport.Usable) whose Connect succeeds becomes the active
+	// transport for the rest of the connection's lifetime. Defaults to a
+	// single WebSocketTransport.
+	Transports []Transport
+
+	// activeTransport is the transport that produced the current Conn.
+	// It is set by Connect and consulted by Start and Reconnect.
+	activeTransport Transport
+
+	// Logger receives one line per diagnostic event that would otherwise
+	// be silently discarded, e.g. a retried negotiate or a reconnect
+	// attempt that failed. It is satisfied by *log.Logger. Leave it nil
+	// to disable logging entirely.
+	Logger Logger
+
+	// OnNegotiate, if set, is called after every Negotiate/NegotiateContext
+	// attempt with the error it returned (nil on success).
+	OnNegotiate func(err error)
+
+	// OnConnect, if set, is called once per transport that
+	// Connect/ConnectContext attempts, naming the transport and the error
+	// it returned (nil on success).
+	OnConnect func(transportName string, err error)
+
+	// OnStart, if set, is called after Start/StartContext finishes, with
+	// the error it returned (nil on success).
+	OnStart func(err error)
+
+	// OnReconnect, if set, is called after every attempt made by the
+	// automatic reconnect loop, with the error it returned (nil on
+	// success).
+	OnReconnect func(err error)
+
+	// OnMessage, if set, is called with every non-KeepAlive message
+	// delivered on Messages().
+	OnMessage func(msg Message)
+
+	// OnError, if set, is called with every error that would otherwise
+	// only reach Logger, e.g. a failed reconnect attempt or a malformed
+	// inbound message.
+	OnError func(err error)
+
+	// HTTPMiddleware, if set, wraps HTTPClient's transport the first time
+	// a request is made, so callers can layer in logging, metrics, or
+	// tracing around every negotiate/start/send/poll request without
+	// replacing HTTPClient wholesale. Entries are applied in order, each
+	// wrapping the one before it, so HTTPMiddleware[0] is the innermost
+	// layer (closest to the wire) and HTTPMiddleware[len-1] is the
+	// outermost. It has no effect on the websocket transport's handshake,
+	// which does not go through HTTPClient.
+	HTTPMiddleware []func(http.RoundTripper) http.RoundTripper
+
+	// httpMiddlewareApplied tracks whether HTTPMiddleware has already
+	// been applied to HTTPClient, so it isn't wrapped twice.
+	httpMiddlewareApplied bool
+
+	// proxyApplied tracks whether Proxy has already been synced onto
+	// HTTPClient's transport, so a later call doesn't clobber the
+	// transport HTTPMiddleware has wrapped.
+	proxyApplied bool
+
+	// httpTransport caches the *http.Transport that Proxy and
+	// TLSClientConfig are applied to. Once set, it is reused directly
+	// instead of being re-derived from HTTPClient.Transport, so calling
+	// ConfigureTLS after HTTPMiddleware has wrapped that transport still
+	// mutates the transport middleware actually wraps, rather than
+	// silently replacing it. Proxy is synced onto it only once (see
+	// applyProxy).
+	httpTransport *http.Transport
+
+	messages chan Message
+}
+
+// New creates and initializes a SignalR client.
+func New(host, protocol, endpoint, connectionData string) (c *Client) {
+	c = new(Client)
+
+	c.Host = host
+	c.Protocol = protocol
+	c.Endpoint = endpoint
+	c.ConnectionData = connectionData
+
+	c.HTTPClient = new(http.Client)
+
+	// Default to honoring the standard proxy environment variables, like
+	// every other net/http-based client.
+	c.Proxy = http.ProxyFromEnvironment
+
+	// Default to using a secure scheme.
+	c.Scheme = HTTPS
+
+	// Set the default max number of negotiate retries.
+	c.MaxNegotiateRetries = 5
+
+	// Set the default sleep duration between retries.
+	c.RetryWaitDuration = 1 * time.Minute
+
+	// Set the default automatic reconnect backoff parameters.
+	c.ReconnectBackoffBase = 1 * time.Second
+	c.ReconnectBackoffMax = 30 * time.Second
+	c.ReconnectBackoffJitter = 0.2
+
+	// Default to websockets, the transport SignalR servers support most
+	// widely.
+	c.TryWebSockets = true
+	c.Transports = []Transport{new(WebSocketTransport)}
+
+	c.messages = make(chan Message)
+
+	return
+}
+
+// Messages returns the channel that inbound messages are delivered on once
+// the connection has been started.
+func (c *Client) Messages() chan Message {
+	return c.messages
+}
+
+// logf writes a formatted diagnostic message to Logger, if one is set.
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
+
+// reportError logs err via logf and, if OnError is set, also passes err to
+// it. It is used for errors that are not returned to the caller directly,
+// e.g. a single failed reconnect attempt in a retry loop.
+func (c *Client) reportError(err error) {
+	c.logf("%v", err)
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// ensureTransport returns the *http.Transport that Proxy and
+// TLSClientConfig get applied to, caching it in httpTransport the first
+// time it's needed. Once cached, the same transport is reused on every
+// later call instead of being re-derived by type-asserting
+// HTTPClient.Transport, which would fail (and silently fall back to a
+// fresh, unconfigured transport) once HTTPMiddleware has wrapped it into
+// something other than a bare *http.Transport.
+func (c *Client) ensureTransport() *http.Transport {
+	if c.httpTransport != nil {
+		return c.httpTransport
+	}
+
+	rt, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok || rt == nil {
+		rt = new(http.Transport)
+	}
+	c.HTTPClient.Transport = rt
+	c.httpTransport = rt
+	return rt
+}
+
+// applyProxy syncs Proxy onto the transport returned by ensureTransport the
+// first time a request is about to be made, so setting Proxy any time
+// before Init (or Negotiate) is all a caller needs to do. It runs before
+// applyHTTPMiddleware so ensureTransport still sees HTTPClient's transport
+// as the caller left it, rather than already wrapped.
+func (c *Client) applyProxy() {
+	if c.proxyApplied {
+		return
+	}
+
+	c.ensureTransport().Proxy = c.Proxy
+	c.proxyApplied = true
+}
+
+// applyHTTPMiddleware wraps HTTPClient's transport with every entry in
+// HTTPMiddleware, in order, the first time a request is about to be made,
+// so setting HTTPMiddleware any time before Init (or Negotiate) is all a
+// caller needs to do.
+func (c *Client) applyHTTPMiddleware() {
+	if len(c.HTTPMiddleware) == 0 || c.httpMiddlewareApplied {
+		return
+	}
+
+	var rt http.RoundTripper = c.ensureTransport()
+	for _, mw := range c.HTTPMiddleware {
+		rt = mw(rt)
+	}
+	c.HTTPClient.Transport = rt
+	c.httpMiddlewareApplied = true
+}
+
+// makeURL builds the URL used for a given step of the SignalR connection
+// sequence, for the named transport (e.g. "webSockets", "serverSentEvents",
+// or "longPolling").
+func (c *Client) makeURL(command, transportName string) (u url.URL) {
+	u.Host = c.Host
+	u.Path = c.Endpoint
+
+	params := url.Values{}
+	params.Set("connectionData", c.ConnectionData)
+	params.Set("clientProtocol", c.Protocol)
+
+	if c.ConnectionToken != "" {
+		params.Set("connectionToken", c.ConnectionToken)
+	}
+
+	if transportName != "" {
+		params.Set("transport", transportName)
+	}
+
+	switch command {
+	case "negotiate":
+		u.Scheme = string(c.Scheme)
+		u.Path += "/negotiate"
+	case "connect":
+		if transportName == webSocketsTransportName {
+			c.setWebsocketScheme(&u)
+		} else {
+			u.Scheme = string(c.Scheme)
+		}
+		u.Path += "/connect"
+	case "reconnect":
+		if transportName == webSocketsTransportName {
+			c.setWebsocketScheme(&u)
+		} else {
+			u.Scheme = string(c.Scheme)
+		}
+		if c.MessageID != "" {
+			params.Set("messageId", c.MessageID)
+		}
+		if c.GroupsToken != "" {
+			params.Set("groupsToken", c.GroupsToken)
+		}
+		u.Path += "/reconnect"
+	case "start":
+		u.Scheme = string(c.Scheme)
+		u.Path += "/start"
+	case "send":
+		u.Scheme = string(c.Scheme)
+		u.Path += "/send"
+	case "poll":
+		u.Scheme = string(c.Scheme)
+		if c.MessageID != "" {
+			params.Set("messageId", c.MessageID)
+		}
+		u.Path += "/poll"
+	}
+
+	u.RawQuery = params.Encode()
+
+	return
+}
+
+// setWebsocketScheme sets u.Scheme to the websocket equivalent of c.Scheme,
+// preserving whether the connection is encrypted.
+func (c *Client) setWebsocketScheme(u *url.URL) {
+	if c.Scheme == HTTPS {
+		u.Scheme = string(WSS)
+	} else {
+		u.Scheme = string(WS)
+	}
+}
+
+// ConfigureTLS sets cfg as TLSClientConfig and propagates it to HTTPClient,
+// so it is a single place to configure TLS consistently for every transport:
+// the http.Client used by Negotiate and Start, the websocket.Dialer used by
+// WebSocketTransport, and any future transport that reads TLSClientConfig.
+// It is safe to call at any point in the client's lifecycle, including
+// after HTTPMiddleware has wrapped HTTPClient.Transport, since it mutates
+// the transport returned by ensureTransport in place instead of replacing
+// HTTPClient.Transport.
+func (c *Client) ConfigureTLS(cfg *tls.Config) {
+	c.TLSClientConfig = cfg
+	c.ensureTransport().TLSClientConfig = cfg
+}
+
+// LoadClientCertificate parses a PEM-encoded certificate and private key and
+// adds them to TLSClientConfig.Certificates, so the server can authenticate
+// this client (mutual TLS). It calls ConfigureTLS first if TLS hasn't been
+// configured yet.
+func (c *Client) LoadClientCertificate(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	if c.TLSClientConfig == nil {
+		c.ConfigureTLS(new(tls.Config))
+	}
+	c.TLSClientConfig.Certificates = append(c.TLSClientConfig.Certificates, cert)
+
+	return nil
+}
+
+// AddRootCA parses a PEM-encoded certificate and adds it to
+// TLSClientConfig.RootCAs, so connections to a server presenting a
+// certificate signed by this CA (e.g. a private or self-signed CA) are
+// trusted. It calls ConfigureTLS first if TLS hasn't been configured yet.
+func (c *Client) AddRootCA(pemBytes []byte) error {
+	if c.TLSClientConfig == nil {
+		c.ConfigureTLS(new(tls.Config))
+	}
+
+	if c.TLSClientConfig.RootCAs == nil {
+		c.TLSClientConfig.RootCAs = x509.NewCertPool()
+	}
+
+	if !c.TLSClientConfig.RootCAs.AppendCertsFromPEM(pemBytes) {
+		return errors.New("add root ca: no certificates found in pemBytes")
+	}
+
+	return nil
+}
+
+// Negotiate implements the negotiate step of the SignalR connection
+// sequence.
+func (c *Client) Negotiate() (err error) {
+	return c.NegotiateContext(context.Background())
+}
+
+// NegotiateContext is like Negotiate, but it carries ctx into the underlying
+// HTTP request and aborts the retry loop as soon as ctx is done.
+func (c *Client) NegotiateContext(ctx context.Context) (err error) {
+	if c.OnNegotiate != nil {
+		defer func() { c.OnNegotiate(err) }()
+	}
+
+	c.applyProxy()
+	c.applyHTTPMiddleware()
+
+	// Reset the connection token in case it has been set by a prior
+	// negotiation.
+	c.ConnectionToken = ""
+
+	u := c.makeURL("negotiate", "")
+
+	var resp *http.Response
+	for i := 0; i < c.MaxNegotiateRetries; i++ {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err = c.HTTPClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			break
+		}
+
+		c.reportError(fmt.Errorf("negotiate: retrying after %s", resp.Status))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.RetryWaitDuration):
+		}
+	}
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return errors.New(resp.Status)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%d status code received", resp.StatusCode)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	parsed := struct {
+		ConnectionToken string
+		ConnectionID    string `json:"ConnectionId"`
+		TryWebSockets   *bool
+	}{}
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return err
+	}
+
+	c.ConnectionToken = parsed.ConnectionToken
+	c.ConnectionID = parsed.ConnectionID
+	if parsed.TryWebSockets != nil {
+		c.TryWebSockets = *parsed.TryWebSockets
+	}
+
+	return nil
+}
+
+// Connect implements the connect step of the SignalR connection sequence,
+// trying each transport in c.Transports in order until one succeeds.
+func (c *Client) Connect() (conn Conn, err error) {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect, but it connects using ctx so that callers
+// can cancel a handshake that is taking too long.
+func (c *Client) ConnectContext(ctx context.Context) (conn Conn, err error) {
+	if len(c.Transports) == 0 {
+		c.Transports = []Transport{new(WebSocketTransport)}
+	}
+
+	var lastErr error
+	for _, t := range c.Transports {
+		if !t.Usable(c) {
+			continue
+		}
+
+		conn, err = t.Connect(ctx, c)
+		if c.OnConnect != nil {
+			c.OnConnect(t.Name(), err)
+		}
+		if err == nil {
+			c.activeTransport = t
+			return conn, nil
+		}
+
+		c.reportError(fmt.Errorf("connect: %s transport failed: %w", t.Name(), err))
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("connect: no usable transport")
+	}
+
+	return nil, lastErr
+}
+
+// permanentReconnectError wraps a reconnect error that should not be
+// retried, e.g. because the server rejected the connection outright.
+type permanentReconnectError struct {
+	err error
+}
+
+func (e *permanentReconnectError) Error() string { return e.err.Error() }
+func (e *permanentReconnectError) Unwrap() error { return e.err }
+
+// classifyReconnectError wraps err in a permanentReconnectError when
+// statusCode is 401 or 403, so attemptReconnect's backoff loop gives up
+// instead of retrying a reconnect the server will never accept. statusCode
+// is 0 when err has nothing to do with an HTTP response (e.g. a dial
+// failure), in which case err is returned unchanged. Every Transport's
+// Reconnect uses this so the same classification applies regardless of
+// which transport is active.
+func classifyReconnectError(statusCode int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		return &permanentReconnectError{err}
+	}
+
+	return err
+}
+
+// Reconnect implements the reconnect step of the SignalR connection
+// sequence, resuming from MessageID and GroupsToken if they are set.
+func (c *Client) Reconnect() (conn Conn, err error) {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext is like Reconnect, but it reconnects using ctx.
+func (c *Client) ReconnectContext(ctx context.Context) (conn Conn, err error) {
+	t := c.activeTransport
+	if t == nil {
+		t = new(WebSocketTransport)
+	}
+
+	conn, err = t.Reconnect(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Conn = conn
+
+	return conn, nil
+}
+
+// jitter randomizes d within a window of d*factor (factor is 0 to 1) centered
+// on d, i.e. by up to factor/2 of d in either direction.
+func jitter(d time.Duration, factor float64) time.Duration {
+	if factor <= 0 || d <= 0 {
+		return d
+	}
+
+	delta := time.Duration(float64(d) * factor)
+	if delta <= 0 {
+		return d
+	}
+
+	return d - delta/2 + time.Duration(rand.Int63n(int64(delta)))
+}
+
+// attemptReconnect retries ReconnectContext using truncated exponential
+// backoff with jitter, up to MaxNegotiateRetries attempts. It returns false
+// if ctx is done, the retries are exhausted, or the server reports a
+// permanent failure (e.g. 401/403).
+func (c *Client) attemptReconnect(ctx context.Context) bool {
+	wait := c.ReconnectBackoffBase
+
+	for i := 0; i < c.MaxNegotiateRetries; i++ {
+		_, err := c.ReconnectContext(ctx)
+		if c.OnReconnect != nil {
+			c.OnReconnect(err)
+		}
+		if err == nil {
+			return true
+		}
+
+		var permErr *permanentReconnectError
+		if errors.As(err, &permErr) {
+			c.reportError(err)
+			return false
+		}
+
+		c.reportError(fmt.Errorf("reconnect attempt %d failed: %w", i, err))
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(jitter(wait, c.ReconnectBackoffJitter)):
+		}
+
+		wait *= 2
+		if wait > c.ReconnectBackoffMax {
+			wait = c.ReconnectBackoffMax
+		}
+	}
+
+	return false
+}
+
+// isPermanentCloseError reports whether err represents a websocket close
+// that should not be retried.
+func isPermanentCloseError(err error) bool {
+	return websocket.IsCloseError(err,
+		websocket.CloseNormalClosure,
+		websocket.CloseProtocolError,
+		websocket.ClosePolicyViolation,
+	)
+}
+
+// wsRead is the result of a single, possibly still in-flight, call to
+// Conn.ReadMessage.
+type wsRead struct {
+	messageType int
+	p           []byte
+	err         error
+}
+
+// readMessageContext reads a single message from conn, returning ctx.Err()
+// if ctx is done before the read completes. The underlying read is not
+// itself cancellable, so on cancellation the read goroutine is left to
+// finish (or fail) on its own once the connection is closed.
+func readMessageContext(ctx context.Context, conn Conn) (int, []byte, error) {
+	resultCh := make(chan wsRead, 1)
+	go func() {
+		messageType, p, err := conn.ReadMessage()
+		resultCh <- wsRead{messageType, p, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	case res := <-resultCh:
+		return res.messageType, res.p, res.err
+	}
+}
+
+// Start implements the start step of the SignalR connection sequence.
+func (c *Client) Start(conn Conn) (err error) {
+	return c.StartContext(context.Background(), conn)
+}
+
+// StartContext is like Start, but it carries ctx into the underlying HTTP
+// request and the wait for the server's init message.
+func (c *Client) StartContext(ctx context.Context, conn Conn) (err error) {
+	if c.OnStart != nil {
+		defer func() { c.OnStart(err) }()
+	}
+
+	t := c.activeTransport
+	if t == nil {
+		t = new(WebSocketTransport)
+	}
+
+	return t.Start(ctx, c, conn)
+}
+
+// Init negotiates, connects, and starts the client, leaving it ready to send
+// and receive messages.
+func (c *Client) Init() (err error) {
+	return c.InitContext(context.Background())
+}
+
+// InitContext is like Init, but it carries ctx through negotiate, connect,
+// and start, and stops the Messages() pump as soon as ctx is done.
+func (c *Client) InitContext(ctx context.Context) (err error) {
+	err = c.NegotiateContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.ConnectContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.StartContext(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	go c.pumpMessages(ctx)
+
+	return nil
+}
+
+// pumpMessages reads messages from the underlying connection and delivers
+// them on the Messages() channel until a permanent error occurs or ctx is
+// done. Transient read errors trigger an automatic reconnect, resuming from
+// the last-seen MessageID and GroupsToken.
+func (c *Client) pumpMessages(ctx context.Context) {
+	for {
+		_, p, err := readMessageContext(ctx, c.Conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			if isPermanentCloseError(err) {
+				c.reportError(err)
+				return
+			}
+
+			if !c.attemptReconnect(ctx) {
+				c.reportError(err)
+				return
+			}
+
+			continue
+		}
+
+		// Ignore KeepAlive messages.
+		if len(p) == 2 && p[0] == '{' && p[1] == '}' {
+			continue
+		}
+
+		var msg Message
+		err = json.Unmarshal(p, &msg)
+		if err != nil {
+			c.reportError(err)
+			continue
+		}
+
+		if msg.C != "" {
+			c.MessageID = msg.C
+		}
+		if msg.G != "" {
+			c.GroupsToken = msg.G
+		}
+
+		if c.OnMessage != nil {
+			c.OnMessage(msg)
+		}
+
+		select {
+		case c.messages <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Send sends a message to the websocket connection.
+func (c *Client) Send(m hubs.ClientMsg) (err error) {
+	return c.SendContext(context.Background(), m)
+}
+
+// SendContext is like Send, but it abandons the write as soon as ctx is
+// done instead of blocking on a stalled connection.
+func (c *Client) SendContext(ctx context.Context, m hubs.ClientMsg) (err error) {
+	if c.Conn == nil {
+		return errors.New("send: connection not set")
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Conn.WriteJSON(m) }()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-errCh:
+		return err
+	}
+}
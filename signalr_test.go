@@ -1,19 +1,32 @@
 package signalr_test
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/carterjones/helpers/trace"
 	"github.com/carterjones/signalr"
 	"github.com/carterjones/signalr/hubs"
 	"github.com/gorilla/websocket"
@@ -129,7 +142,7 @@ func newTestClient(protocol, endpoint, connectionData string, ts *httptest.Serve
 func negotiate(w http.ResponseWriter, r *http.Request) {
 	_, err := w.Write([]byte(`{"ConnectionToken":"hello world","ConnectionId":"1234-ABC","URL":"/signalr","ProtocolVersion":"1337"}`))
 	if err != nil {
-		trace.Error(err)
+		log.Print(err)
 		return
 	}
 }
@@ -138,7 +151,7 @@ func connect(w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{}
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		trace.Error(err)
+		log.Print(err)
 		return
 	}
 
@@ -148,7 +161,7 @@ func connect(w http.ResponseWriter, r *http.Request) {
 			var bs []byte
 			msgType, bs, err = c.ReadMessage()
 			if err != nil {
-				trace.Error(err)
+				log.Print(err)
 				return
 			}
 
@@ -160,7 +173,26 @@ func connect(w http.ResponseWriter, r *http.Request) {
 		for {
 			err = c.WriteMessage(websocket.TextMessage, []byte(`{"S":1}`))
 			if err != nil {
-				trace.Error(err)
+				log.Print(err)
+				return
+			}
+		}
+	}()
+}
+
+func reconnect(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	go func() {
+		for {
+			err = c.WriteMessage(websocket.TextMessage, []byte(`{"S":1}`))
+			if err != nil {
+				log.Print(err)
 				return
 			}
 		}
@@ -170,7 +202,7 @@ func connect(w http.ResponseWriter, r *http.Request) {
 func start(w http.ResponseWriter, r *http.Request) {
 	_, err := w.Write([]byte(`{"Response":"started"}`))
 	if err != nil {
-		trace.Error(err)
+		log.Print(err)
 	}
 }
 
@@ -308,9 +340,8 @@ func TestClient_Connect(t *testing.T) {
 			errMatches(t, id, err, tc.wantErr)
 		} else {
 			ok(t, id, err)
+			notNil(t, id, conn)
 		}
-
-		notNil(t, id, conn)
 	}
 }
 
@@ -419,6 +450,122 @@ func TestClient_Start(t *testing.T) {
 }
 
 func TestClient_Reconnect(t *testing.T) {
+	cases := map[string]struct {
+		fn          http.HandlerFunc
+		messageID   string
+		groupsToken string
+		wantErr     string
+	}{
+		"successful reconnect": {
+			fn: reconnect,
+		},
+		"resume with message id": {
+			fn:        reconnect,
+			messageID: "message-id-123",
+		},
+		"resume with groups token": {
+			fn:          reconnect,
+			groupsToken: "groups-token-abc",
+		},
+		"unauthorized": {
+			fn: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			wantErr: websocket.ErrBadHandshake.Error(),
+		},
+	}
+
+	for id, tc := range cases {
+		var gotMessageID, gotGroupsToken string
+		ts := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+			gotMessageID = r.URL.Query().Get("messageId")
+			gotGroupsToken = r.URL.Query().Get("groupsToken")
+			tc.fn(w, r)
+		}, true)
+		defer ts.Close()
+
+		c := newTestClient("", "", "", ts)
+		c.MessageID = tc.messageID
+		c.GroupsToken = tc.groupsToken
+
+		conn, err := c.Reconnect()
+
+		if tc.wantErr != "" {
+			errMatches(t, id, err, tc.wantErr)
+		} else {
+			ok(t, id, err)
+			notNil(t, id, conn)
+			equals(t, id, conn, c.Conn)
+			equals(t, id, tc.messageID, gotMessageID)
+			equals(t, id, tc.groupsToken, gotGroupsToken)
+		}
+	}
+}
+
+// TestClient_Init_ReconnectsAfterTransientFailure simulates a server that
+// drops the websocket connection once (a stand-in for a restart or a
+// temporary network failure) and verifies that the Messages() pump
+// transparently reconnects and keeps delivering messages.
+func TestClient_Init_ReconnectsAfterTransientFailure(t *testing.T) {
+	var connectCount int
+
+	ts := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/negotiate"):
+			negotiate(w, r)
+		case strings.Contains(r.URL.Path, "/connect"):
+			connectCount++
+
+			upgrader := websocket.Upgrader{}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+
+			if connectCount == 1 {
+				// Simulate a server restart: send the init
+				// frame, then immediately drop the connection.
+				err = conn.WriteMessage(websocket.TextMessage, []byte(`{"S":1}`))
+				if err != nil {
+					log.Print(err)
+				}
+				conn.Close()
+				return
+			}
+
+			// On the second connection, stay up and keep sending
+			// the init frame so that reads continue to succeed.
+			go func() {
+				for {
+					werr := conn.WriteMessage(websocket.TextMessage, []byte(`{"S":1}`))
+					if werr != nil {
+						return
+					}
+				}
+			}()
+		case strings.Contains(r.URL.Path, "/reconnect"):
+			connect(w, r)
+		case strings.Contains(r.URL.Path, "/start"):
+			start(w, r)
+		}
+	}), true)
+	defer ts.Close()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.RetryWaitDuration = 1 * time.Millisecond
+	c.ReconnectBackoffBase = 1 * time.Millisecond
+	c.ReconnectBackoffMax = 5 * time.Millisecond
+
+	err := c.Init()
+	ok(t, "init", err)
+
+	select {
+	case <-c.Messages():
+		// A message arrived on the reconnected connection.
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for a message after reconnect")
+	}
 }
 
 func TestClient_Init(t *testing.T) {
@@ -447,6 +594,240 @@ func TestClient_Init(t *testing.T) {
 	// TODO: check for specific errors
 }
 
+func TestClient_Hooks(t *testing.T) {
+	ts := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/negotiate") {
+			negotiate(w, r)
+		} else if strings.Contains(r.URL.Path, "/connect") {
+			connect(w, r)
+		} else if strings.Contains(r.URL.Path, "/start") {
+			start(w, r)
+		} else {
+			log.Println("url:", r.URL)
+		}
+	}), true)
+	defer ts.Close()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+
+	var negotiateCalled, startCalled, connectCalled bool
+	var negotiateErr, startErr, connectErr error
+	var connectTransportName string
+	c.OnNegotiate = func(err error) {
+		negotiateCalled = true
+		negotiateErr = err
+	}
+	c.OnConnect = func(transportName string, err error) {
+		connectCalled = true
+		connectTransportName = transportName
+		connectErr = err
+	}
+	c.OnStart = func(err error) {
+		startCalled = true
+		startErr = err
+	}
+
+	messages := make(chan signalr.Message, 1)
+	c.OnMessage = func(msg signalr.Message) {
+		select {
+		case messages <- msg:
+		default:
+		}
+	}
+
+	err := c.Init()
+	ok(t, "init", err)
+
+	equals(t, "OnNegotiate called", true, negotiateCalled)
+	ok(t, "OnNegotiate err", negotiateErr)
+	equals(t, "OnConnect called", true, connectCalled)
+	equals(t, "OnConnect transport name", "webSockets", connectTransportName)
+	ok(t, "OnConnect err", connectErr)
+	equals(t, "OnStart called", true, startCalled)
+	ok(t, "OnStart err", startErr)
+
+	select {
+	case msg := <-messages:
+		equals(t, "message S", 1, msg.S)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for OnMessage")
+	}
+}
+
+// memoryLogger collects every line logged through it, so tests can assert on
+// what Client reported without needing a real *log.Logger.
+type memoryLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *memoryLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *memoryLogger) containsSubstring(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestClient_Logger_NegotiateRetry verifies that Logger and OnError both
+// receive the line Negotiate reports each time it retries after a 503.
+func TestClient_Logger_NegotiateRetry(t *testing.T) {
+	var negotiateCount int
+	ts := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		negotiateCount++
+		if negotiateCount == 1 {
+			throw503Error(w, r)
+			return
+		}
+		negotiate(w, r)
+	}), true)
+	defer ts.Close()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.RetryWaitDuration = 1 * time.Millisecond
+
+	logger := &memoryLogger{}
+	c.Logger = logger
+
+	var onErrorCalls int
+	var lastOnError error
+	c.OnError = func(err error) {
+		onErrorCalls++
+		lastOnError = err
+	}
+
+	err := c.Negotiate()
+	ok(t, "negotiate", err)
+
+	equals(t, "negotiate call count", 2, negotiateCount)
+	equals(t, "Logger received a retry line", true, logger.containsSubstring("retrying"))
+	equals(t, "OnError called", 1, onErrorCalls)
+	errMatches(t, "OnError err", lastOnError, "retrying")
+}
+
+// TestClient_Logger_ReconnectRetry verifies that Logger receives a line for
+// the failed attempts of the automatic reconnect loop, and that OnReconnect
+// is called for every attempt, including the one that finally succeeds.
+func TestClient_Logger_ReconnectRetry(t *testing.T) {
+	var connectCount, reconnectCount int
+
+	ts := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/negotiate"):
+			negotiate(w, r)
+		case strings.Contains(r.URL.Path, "/connect"):
+			connectCount++
+
+			upgrader := websocket.Upgrader{}
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+
+			if connectCount == 1 {
+				// Simulate a server restart: send the init
+				// frame, then immediately drop the connection.
+				err = conn.WriteMessage(websocket.TextMessage, []byte(`{"S":1}`))
+				if err != nil {
+					log.Print(err)
+				}
+				conn.Close()
+				return
+			}
+
+			go func() {
+				for {
+					werr := conn.WriteMessage(websocket.TextMessage, []byte(`{"S":1}`))
+					if werr != nil {
+						return
+					}
+				}
+			}()
+		case strings.Contains(r.URL.Path, "/reconnect"):
+			reconnectCount++
+			if reconnectCount < 3 {
+				// Fail the first two reconnect attempts with a
+				// retryable (non-401/403) error.
+				throw123Error(w, r)
+				return
+			}
+			connect(w, r)
+		case strings.Contains(r.URL.Path, "/start"):
+			start(w, r)
+		}
+	}), true)
+	defer ts.Close()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.RetryWaitDuration = 1 * time.Millisecond
+	c.ReconnectBackoffBase = 1 * time.Millisecond
+	c.ReconnectBackoffMax = 5 * time.Millisecond
+
+	logger := &memoryLogger{}
+	c.Logger = logger
+
+	var onReconnectCalls int
+	c.OnReconnect = func(err error) {
+		onReconnectCalls++
+	}
+
+	err := c.Init()
+	ok(t, "init", err)
+
+	select {
+	case <-c.Messages():
+		// A message arrived on the reconnected connection.
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message after reconnect")
+	}
+
+	equals(t, "Logger received a reconnect retry line", true, logger.containsSubstring("reconnect attempt"))
+	if onReconnectCalls < 3 {
+		t.Errorf("OnReconnect calls: exp >= 3, got %d", onReconnectCalls)
+	}
+}
+
+// TestClient_HTTPMiddleware_WrapsTransport verifies that HTTPMiddleware
+// wraps the transport actually used by a real request, and that multiple
+// entries are layered in order.
+func TestClient_HTTPMiddleware_WrapsTransport(t *testing.T) {
+	ts := newTestServer(http.HandlerFunc(negotiate), true)
+	defer ts.Close()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+
+	var order []string
+	wrap := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(rt http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return rt.RoundTrip(req)
+			})
+		}
+	}
+	// HTTPMiddleware[0] is the innermost layer, so HTTPMiddleware[1] wraps
+	// around it and runs first at request time.
+	c.HTTPMiddleware = []func(http.RoundTripper) http.RoundTripper{
+		wrap("inner"),
+		wrap("outer"),
+	}
+
+	err := c.Negotiate()
+	ok(t, "negotiate", err)
+
+	equals(t, "middleware order", []string{"outer", "inner"}, order)
+}
+
 type FakeConn struct {
 	err  error
 	data interface{}
@@ -514,6 +895,507 @@ func TestClient_Send(t *testing.T) {
 	}
 }
 
+// SlowConn is a fake Conn whose WriteJSON call blocks until released, so that
+// tests can exercise SendContext's cancellation path.
+type SlowConn struct {
+	release chan struct{}
+}
+
+func (c *SlowConn) ReadMessage() (messageType int, p []byte, err error) {
+	<-c.release
+	return
+}
+
+func (c *SlowConn) WriteJSON(v interface{}) (err error) {
+	<-c.release
+	return
+}
+
+func TestClient_NegotiateContext_Cancelled(t *testing.T) {
+	ts := newTestServer(http.HandlerFunc(negotiate), false)
+	defer ts.Close()
+
+	c := newTestClient("1337", "/signalr", "all the data", ts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := c.NegotiateContext(ctx)
+	errMatches(t, "cancelled negotiate", err, context.Canceled.Error())
+}
+
+func TestClient_SendContext_Cancelled(t *testing.T) {
+	c := signalr.New("", "", "", "")
+	c.Conn = &SlowConn{release: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := c.SendContext(ctx, hubs.ClientMsg{H: "test data 123"})
+	errMatches(t, "cancelled send", err, context.Canceled.Error())
+}
+
+// serverSentEventsServer returns a handler that serves the negotiate,
+// connect, start, and send steps of the serverSentEvents transport. It
+// writes a single init frame on connect, and answers each post to /send
+// with the posted data in that request's response body.
+func serverSentEventsServer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/negotiate"):
+			negotiate(w, r)
+		case strings.Contains(r.URL.Path, "/start"):
+			start(w, r)
+		case strings.Contains(r.URL.Path, "/connect"):
+			flusher, ok := w.(http.Flusher)
+			if !ok {
+				log.Panic("response writer does not support flushing")
+			}
+
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, "data: {\"S\":1}\n\n")
+			flusher.Flush()
+
+			<-r.Context().Done()
+		case strings.Contains(r.URL.Path, "/send"):
+			err := r.ParseForm()
+			if err != nil {
+				log.Print(err)
+				return
+			}
+
+			_, err = w.Write([]byte(r.PostForm.Get("data")))
+			if err != nil {
+				log.Print(err)
+			}
+		default:
+			log.Println("url:", r.URL)
+		}
+	}
+}
+
+func TestClient_ServerSentEventsTransport(t *testing.T) {
+	ts := newTestServer(serverSentEventsServer(), true)
+	defer ts.Close()
+	// The /connect handler blocks on r.Context().Done(), which only fires
+	// once the connection is closed; Close() won't force-close an active
+	// connection, so force it here to avoid deadlocking on shutdown.
+	defer ts.CloseClientConnections()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.Transports = []signalr.Transport{new(signalr.ServerSentEventsTransport)}
+
+	err := c.Init()
+	ok(t, "init", err)
+
+	err = c.Send(hubs.ClientMsg{H: "chat", M: "send"})
+	ok(t, "send", err)
+}
+
+// TestClient_Connect_FallsBackToNextTransport verifies that Connect skips a
+// transport the negotiate response has marked unusable, and falls back to
+// the next one in c.Transports.
+func TestClient_Connect_FallsBackToNextTransport(t *testing.T) {
+	ts := newTestServer(serverSentEventsServer(), true)
+	defer ts.Close()
+	defer ts.CloseClientConnections()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.TryWebSockets = false
+	c.Transports = []signalr.Transport{
+		new(signalr.WebSocketTransport),
+		new(signalr.ServerSentEventsTransport),
+	}
+
+	conn, err := c.Connect()
+	ok(t, "connect", err)
+	notNil(t, "connect", conn)
+}
+
+// longPollingServer returns a handler that serves the negotiate, start,
+// poll, and send steps of the longPolling transport. The first poll returns
+// an empty "{}" response, simulating a server that timed out waiting for data, so
+// tests also exercise LongPollingTransport's retry-on-empty-poll behavior;
+// every poll after that returns the init message.
+func longPollingServer() http.HandlerFunc {
+	polls := 0
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/negotiate"):
+			negotiate(w, r)
+		case strings.Contains(r.URL.Path, "/start"):
+			start(w, r)
+		case strings.Contains(r.URL.Path, "/poll"):
+			polls++
+			if polls == 1 {
+				fmt.Fprint(w, "{}")
+				return
+			}
+
+			fmt.Fprint(w, `{"S":1}`)
+		case strings.Contains(r.URL.Path, "/send"):
+			err := r.ParseForm()
+			if err != nil {
+				log.Print(err)
+				return
+			}
+
+			_, err = w.Write([]byte(r.PostForm.Get("data")))
+			if err != nil {
+				log.Print(err)
+			}
+		default:
+			log.Println("url:", r.URL)
+		}
+	}
+}
+
+func TestClient_LongPollingTransport(t *testing.T) {
+	ts := newTestServer(longPollingServer(), true)
+	defer ts.Close()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.Transports = []signalr.Transport{new(signalr.LongPollingTransport)}
+
+	err := c.Init()
+	ok(t, "init", err)
+
+	err = c.Send(hubs.ClientMsg{H: "chat", M: "send"})
+	ok(t, "send", err)
+}
+
+// generateTestCA creates a self-signed CA certificate, returned both as PEM
+// bytes (for feeding into AddRootCA) and parsed (for signing leaf
+// certificates with generateTestLeafCert).
+func generateTestCA(t *testing.T) (certPEM []byte, cert *x509.Certificate, key *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	ok(t, "generate CA key", err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "signalr test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	ok(t, "create CA certificate", err)
+
+	cert, err = x509.ParseCertificate(der)
+	ok(t, "parse CA certificate", err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	return certPEM, cert, key
+}
+
+// generateTestLeafCert issues a certificate for cn (valid for dnsNames, if
+// any) signed by caCert/caKey, returning the certificate and private key as
+// PEM bytes.
+func generateTestLeafCert(t *testing.T, caCert *x509.Certificate, caKey *rsa.PrivateKey, cn string, dnsNames []string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	ok(t, "generate leaf key", err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+
+	if ip := net.ParseIP(cn); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	ok(t, "create leaf certificate", err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM
+}
+
+// TestClient_MutualTLS drives the full Init sequence against a server that
+// requires and verifies a client certificate, proving that ConfigureTLS,
+// LoadClientCertificate, and AddRootCA propagate consistently to both the
+// http.Client used by Negotiate/Start and the websocket.Dialer used by
+// WebSocketTransport.
+func TestClient_MutualTLS(t *testing.T) {
+	caCertPEM, caCert, caKey := generateTestCA(t)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/negotiate"):
+			negotiate(w, r)
+		case strings.Contains(r.URL.Path, "/connect"):
+			connect(w, r)
+		case strings.Contains(r.URL.Path, "/start"):
+			start(w, r)
+		default:
+			log.Println("url:", r.URL)
+		}
+	}))
+
+	serverCertPEM, serverKeyPEM := generateTestLeafCert(t, caCert, caKey, "127.0.0.1", []string{"127.0.0.1"})
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	ok(t, "server certificate", err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(caCertPEM)
+
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	clientCertPEM, clientKeyPEM := generateTestLeafCert(t, caCert, caKey, "signalr test client", nil)
+
+	c := signalr.New(hostFromServerURL(ts.URL), "1.5", "/signalr", "all the data")
+	c.Scheme = signalr.HTTPS
+
+	c.ConfigureTLS(new(tls.Config))
+
+	err = c.AddRootCA(caCertPEM)
+	ok(t, "add root ca", err)
+
+	err = c.LoadClientCertificate(clientCertPEM, clientKeyPEM)
+	ok(t, "load client certificate", err)
+
+	err = c.Init()
+	ok(t, "init", err)
+}
+
+// roundTripFunc adapts a function to the http.RoundTripper interface, the
+// way http.HandlerFunc adapts a function to http.Handler.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// TestClient_ConfigureTLS_AfterHTTPMiddleware verifies that calling
+// ConfigureTLS after HTTPMiddleware has already wrapped HTTPClient's
+// transport still reaches every later request, instead of silently
+// replacing the middleware-wrapped transport with a fresh, unwrapped one.
+func TestClient_ConfigureTLS_AfterHTTPMiddleware(t *testing.T) {
+	ts := newTestServer(http.HandlerFunc(negotiate), true)
+	defer ts.Close()
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+
+	var middlewareCalls int
+	c.HTTPMiddleware = []func(http.RoundTripper) http.RoundTripper{
+		func(rt http.RoundTripper) http.RoundTripper {
+			return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				middlewareCalls++
+				return rt.RoundTrip(req)
+			})
+		},
+	}
+
+	err := c.Negotiate()
+	ok(t, "negotiate", err)
+	equals(t, "middleware calls after first negotiate", 1, middlewareCalls)
+
+	// Reconfigure TLS now that HTTPMiddleware has already wrapped the
+	// transport. Use a cert pool that explicitly trusts ts's certificate,
+	// so a second negotiate can only succeed if both the middleware
+	// wrapper and the new TLS config are in effect at once.
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	c.ConfigureTLS(&tls.Config{RootCAs: pool})
+
+	err = c.Negotiate()
+	ok(t, "negotiate after ConfigureTLS", err)
+	equals(t, "middleware calls after second negotiate", 2, middlewareCalls)
+}
+
+// connectProxy returns an HTTP proxy server that handles CONNECT by
+// tunneling raw bytes to the requested authority, so Client.Proxy can be
+// pointed at it to verify a request traverses an HTTP CONNECT proxy.
+func connectProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "expected CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "hijack unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		client, _, err := hijacker.Hijack()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		defer client.Close()
+
+		if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			log.Print(err)
+			return
+		}
+
+		done := make(chan struct{}, 2)
+		go func() { io.Copy(target, client); done <- struct{}{} }()
+		go func() { io.Copy(client, target); done <- struct{}{} }()
+		<-done
+	}))
+}
+
+// TestClient_Negotiate_HTTPProxy verifies that setting Client.Proxy routes
+// the REST leg (negotiate) through an HTTP CONNECT proxy.
+func TestClient_Negotiate_HTTPProxy(t *testing.T) {
+	ts := newTestServer(http.HandlerFunc(negotiate), true)
+	defer ts.Close()
+
+	proxy := connectProxy(t)
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	ok(t, "parse proxy url", err)
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.Proxy = http.ProxyURL(proxyURL)
+
+	err = c.Negotiate()
+	ok(t, "negotiate", err)
+}
+
+// serveMinimalSOCKS5 speaks just enough of SOCKS5 on conn to accept a
+// no-auth handshake and a CONNECT request for an IPv4 address, then
+// tunnels raw bytes to that address.
+func serveMinimalSOCKS5(conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, 262)
+
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		log.Print(err)
+		return
+	}
+	nmethods := int(buf[1])
+	if _, err := io.ReadFull(conn, buf[:nmethods]); err != nil {
+		log.Print(err)
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		log.Print(err)
+		return
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		log.Print(err)
+		return
+	}
+	if buf[3] != 1 {
+		log.Printf("serveMinimalSOCKS5: unsupported address type: %d", buf[3])
+		return
+	}
+
+	if _, err := io.ReadFull(conn, buf[:6]); err != nil {
+		log.Print(err)
+		return
+	}
+	ip := net.IP(buf[:4])
+	port := binary.BigEndian.Uint16(buf[4:6])
+
+	target, err := net.Dial("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer target.Close()
+
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		log.Print(err)
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(target, conn); done <- struct{}{} }()
+	go func() { io.Copy(conn, target); done <- struct{}{} }()
+	<-done
+}
+
+// minimalSOCKS5Proxy starts a listener that runs serveMinimalSOCKS5 on every
+// accepted connection, until the listener is closed.
+func minimalSOCKS5Proxy(t *testing.T) net.Listener {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, "listen", err)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveMinimalSOCKS5(conn)
+		}
+	}()
+
+	return ln
+}
+
+// TestClient_Init_SOCKS5Proxy verifies that setting Client.Proxy to a
+// socks5:// URL routes both the REST leg and the websocket dial through a
+// SOCKS5 proxy.
+func TestClient_Init_SOCKS5Proxy(t *testing.T) {
+	ts := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/negotiate"):
+			negotiate(w, r)
+		case strings.Contains(r.URL.Path, "/connect"):
+			connect(w, r)
+		case strings.Contains(r.URL.Path, "/start"):
+			start(w, r)
+		default:
+			log.Println("url:", r.URL)
+		}
+	}), false)
+	defer ts.Close()
+
+	proxy := minimalSOCKS5Proxy(t)
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse("socks5://" + proxy.Addr().String())
+	ok(t, "parse proxy url", err)
+
+	c := newTestClient("1.5", "/signalr", "all the data", ts)
+	c.Proxy = http.ProxyURL(proxyURL)
+
+	err = c.Init()
+	ok(t, "init", err)
+}
+
 func TestNew(t *testing.T) {
 	// Define parameter values.
 	host := "test-host"
@@ -0,0 +1,445 @@
+package signalr
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Transport implements the connect, start, and reconnect steps of the
+// SignalR connection sequence for a specific transport (websockets,
+// Server-Sent Events, or long polling). Negotiate is not part of this
+// interface because it is a single HTTP round trip shared by every
+// transport; see Client.Negotiate.
+type Transport interface {
+	// Name is the value SignalR expects in the "transport" query string
+	// parameter, e.g. "webSockets", "serverSentEvents", or
+	// "longPolling".
+	Name() string
+
+	// Usable reports whether this transport should be attempted for c,
+	// e.g. WebSocketTransport is unusable once a negotiate response sets
+	// c.TryWebSockets to false.
+	Usable(c *Client) bool
+
+	// Connect establishes this transport's connection to the server and
+	// returns a Conn that the caller should pass to Start.
+	Connect(ctx context.Context, c *Client) (Conn, error)
+
+	// Start performs the start step once Connect has succeeded, waiting
+	// for the server's init message before returning.
+	Start(ctx context.Context, c *Client, conn Conn) error
+
+	// Reconnect reestablishes the connection after it was lost, resuming
+	// from c.MessageID and c.GroupsToken, and returns the new Conn.
+	Reconnect(ctx context.Context, c *Client) (Conn, error)
+}
+
+// startRequest performs the GET /start request shared by every transport and
+// then blocks until the server's init message is read from conn.
+func startRequest(ctx context.Context, c *Client, transportName string, conn Conn) (messageType int, payload []byte, err error) {
+	u := c.makeURL("start", transportName)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	parsed := struct{ Response string }{}
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if parsed.Response != "started" {
+		return 0, nil, fmt.Errorf("start response is not 'started': %s", parsed.Response)
+	}
+
+	return readMessageContext(ctx, conn)
+}
+
+// parseInitMessage unmarshals a server init message and confirms it reports
+// that the transport was initialized.
+func parseInitMessage(p []byte) error {
+	var msg Message
+	err := json.Unmarshal(p, &msg)
+	if err != nil {
+		return err
+	}
+
+	const serverInitialized = 1
+	if msg.S != serverInitialized {
+		return fmt.Errorf("unexpected S value received from server: %d | message: %s", msg.S, string(p))
+	}
+
+	return nil
+}
+
+// postForm posts form to target and discards a successful response body.
+func postForm(ctx context.Context, client *http.Client, target string, form url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%d status code received", resp.StatusCode)
+	}
+
+	return nil
+}
+
+const webSocketsTransportName = "webSockets"
+
+// WebSocketTransport is the default Transport. It uses a persistent
+// websocket connection for both reading and writing.
+type WebSocketTransport struct{}
+
+// Name implements Transport.
+func (t *WebSocketTransport) Name() string { return webSocketsTransportName }
+
+// Usable implements Transport.
+func (t *WebSocketTransport) Usable(c *Client) bool { return c.TryWebSockets }
+
+// Connect implements Transport.
+func (t *WebSocketTransport) Connect(ctx context.Context, c *Client) (Conn, error) {
+	u := c.makeURL("connect", t.Name())
+
+	dialer := &websocket.Dialer{
+		Proxy:           c.Proxy,
+		TLSClientConfig: c.TLSClientConfig,
+	}
+
+	conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Start implements Transport.
+func (t *WebSocketTransport) Start(ctx context.Context, c *Client, conn Conn) error {
+	messageType, p, err := startRequest(ctx, c, t.Name(), conn)
+	if err != nil {
+		return err
+	}
+
+	if messageType != websocket.TextMessage {
+		return fmt.Errorf("unexpected websocket control type: %d", messageType)
+	}
+
+	if err = parseInitMessage(p); err != nil {
+		return err
+	}
+
+	c.Conn = conn
+
+	return nil
+}
+
+// Reconnect implements Transport.
+func (t *WebSocketTransport) Reconnect(ctx context.Context, c *Client) (Conn, error) {
+	u := c.makeURL("reconnect", t.Name())
+
+	dialer := &websocket.Dialer{
+		Proxy:           c.Proxy,
+		TLSClientConfig: c.TLSClientConfig,
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+
+		return nil, classifyReconnectError(statusCode, err)
+	}
+
+	return conn, nil
+}
+
+// sseConn implements Conn over a SignalR Server-Sent Events stream. Reads
+// parse the next "data: ..." frame out of the open GET response body, and
+// writes are posted to the /send endpoint.
+type sseConn struct {
+	client  *http.Client
+	sendURL string
+	resp    *http.Response
+	reader  *bufio.Reader
+}
+
+func (conn *sseConn) ReadMessage() (messageType int, p []byte, err error) {
+	for {
+		var line string
+		line, err = conn.reader.ReadString('\n')
+		if err != nil {
+			return 0, nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		data := strings.TrimPrefix(line, "data: ")
+		if data == line {
+			// Not a data line (e.g. a comment); the SignalR SSE
+			// transport only ever sends data frames.
+			continue
+		}
+
+		return websocket.TextMessage, []byte(data), nil
+	}
+}
+
+func (conn *sseConn) WriteJSON(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("data", string(body))
+
+	return postForm(context.Background(), conn.client, conn.sendURL, form)
+}
+
+// ServerSentEventsTransport reads from a persistent GET stream and writes by
+// posting to the /send endpoint.
+type ServerSentEventsTransport struct{}
+
+// Name implements Transport.
+func (t *ServerSentEventsTransport) Name() string { return "serverSentEvents" }
+
+// Usable implements Transport.
+func (t *ServerSentEventsTransport) Usable(c *Client) bool { return true }
+
+// dial opens the persistent GET stream that backs an sseConn.
+func (t *ServerSentEventsTransport) dial(ctx context.Context, c *Client, command string) (*http.Response, error) {
+	u := c.makeURL(command, t.Name())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return resp, fmt.Errorf("%d status code received", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// Connect implements Transport.
+func (t *ServerSentEventsTransport) Connect(ctx context.Context, c *Client) (Conn, error) {
+	resp, err := t.dial(ctx, c, "connect")
+	if err != nil {
+		return nil, err
+	}
+
+	sendURL := c.makeURL("send", t.Name())
+
+	return &sseConn{
+		client:  c.HTTPClient,
+		sendURL: sendURL.String(),
+		resp:    resp,
+		reader:  bufio.NewReader(resp.Body),
+	}, nil
+}
+
+// Start implements Transport.
+func (t *ServerSentEventsTransport) Start(ctx context.Context, c *Client, conn Conn) error {
+	_, p, err := startRequest(ctx, c, t.Name(), conn)
+	if err != nil {
+		return err
+	}
+
+	if err = parseInitMessage(p); err != nil {
+		return err
+	}
+
+	c.Conn = conn
+
+	return nil
+}
+
+// Reconnect implements Transport.
+func (t *ServerSentEventsTransport) Reconnect(ctx context.Context, c *Client) (Conn, error) {
+	resp, err := t.dial(ctx, c, "reconnect")
+	if err != nil {
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+
+		return nil, classifyReconnectError(statusCode, err)
+	}
+
+	sendURL := c.makeURL("send", t.Name())
+
+	return &sseConn{
+		client:  c.HTTPClient,
+		sendURL: sendURL.String(),
+		resp:    resp,
+		reader:  bufio.NewReader(resp.Body),
+	}, nil
+}
+
+// longPollConn implements Conn by issuing a new POST to /poll each time
+// ReadMessage is called, blocking until the server responds with data.
+type longPollConn struct {
+	client  *http.Client
+	pollURL string
+	sendURL string
+}
+
+func (conn *longPollConn) ReadMessage() (messageType int, p []byte, err error) {
+	for {
+		var resp *http.Response
+		resp, err = conn.client.Post(conn.pollURL, "application/x-www-form-urlencoded", nil)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return 0, nil, fmt.Errorf("%d status code received", resp.StatusCode)
+		}
+
+		// An empty poll means the server timed out waiting for data;
+		// poll again immediately.
+		if len(body) == 0 || string(body) == "{}" {
+			continue
+		}
+
+		return websocket.TextMessage, body, nil
+	}
+}
+
+func (conn *longPollConn) WriteJSON(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("data", string(body))
+
+	return postForm(context.Background(), conn.client, conn.sendURL, form)
+}
+
+// LongPollingTransport reads by repeatedly polling the /poll endpoint and
+// writes by posting to the /send endpoint. It is the least efficient
+// transport and is meant as a last-resort fallback.
+type LongPollingTransport struct{}
+
+// Name implements Transport.
+func (t *LongPollingTransport) Name() string { return "longPolling" }
+
+// Usable implements Transport.
+func (t *LongPollingTransport) Usable(c *Client) bool { return true }
+
+// Connect implements Transport.
+func (t *LongPollingTransport) Connect(ctx context.Context, c *Client) (Conn, error) {
+	pollURL := c.makeURL("poll", t.Name())
+	sendURL := c.makeURL("send", t.Name())
+
+	return &longPollConn{
+		client:  c.HTTPClient,
+		pollURL: pollURL.String(),
+		sendURL: sendURL.String(),
+	}, nil
+}
+
+// Start implements Transport.
+func (t *LongPollingTransport) Start(ctx context.Context, c *Client, conn Conn) error {
+	_, p, err := startRequest(ctx, c, t.Name(), conn)
+	if err != nil {
+		return err
+	}
+
+	if err = parseInitMessage(p); err != nil {
+		return err
+	}
+
+	c.Conn = conn
+
+	return nil
+}
+
+// Reconnect implements Transport. Unlike Connect, it posts once to the
+// reconnect endpoint so a permanent failure (401/403) can be classified the same
+// way WebSocketTransport and ServerSentEventsTransport do; otherwise
+// attemptReconnect would retry a reconnect the server has permanently
+// rejected forever, since an ordinary longPollConn never talks to the
+// server until its first ReadMessage. Any data returned by that request
+// is discarded; pumpMessages will pick up the next message normally.
+func (t *LongPollingTransport) Reconnect(ctx context.Context, c *Client) (Conn, error) {
+	probeURL := c.makeURL("reconnect", t.Name())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, probeURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, classifyReconnectError(0, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, classifyReconnectError(resp.StatusCode, fmt.Errorf("%d status code received", resp.StatusCode))
+	}
+
+	pollURL := c.makeURL("poll", t.Name())
+	sendURL := c.makeURL("send", t.Name())
+
+	return &longPollConn{
+		client:  c.HTTPClient,
+		pollURL: pollURL.String(),
+		sendURL: sendURL.String(),
+	}, nil
+}